@@ -0,0 +1,44 @@
+// Command protoc-gen-ketty is a standalone protoc plugin that emits ketty
+// service bindings into their own foo_ketty.pb.go file, independent of
+// protoc-gen-go. Invoke it as:
+//
+//	protoc --go_out=. --ketty_out=. foo.proto
+//
+// or, to also emit transparent StreamDirector-based proxy stubs:
+//
+//	protoc --go_out=. --ketty_out=proxy=true:. foo.proto
+//
+// or, to wrap every unary client/server method with Prometheus metrics
+// (streaming methods are not instrumented):
+//
+//	protoc --go_out=. --ketty_out=metrics=prometheus:. foo.proto
+//
+// which lets users upgrade protoc-gen-go without rebuilding this plugin. See
+// ketty/plugin.go for the alternative in-process mode, which links ketty
+// straight into protoc-gen-go behind the "legacy" build tag.
+package main
+
+import (
+	"flag"
+
+	"google.golang.org/protobuf/compiler/protogen"
+
+	"github.com/yyzybb537/protoc-gen-ketty/ketty"
+)
+
+func main() {
+	var flags flag.FlagSet
+	proxy := flags.Bool("proxy", false, "also emit a RegisterKettyXxxProxy StreamDirector-based forwarder per service")
+	metrics := flags.String("metrics", "", "wrap client/server methods with a metrics implementation; only \"prometheus\" is supported")
+
+	protogen.Options{ParamFunc: flags.Set}.Run(func(gen *protogen.Plugin) error {
+		opts := ketty.Options{Proxy: *proxy, Metrics: *metrics}
+		for _, file := range gen.Files {
+			if !file.Generate {
+				continue
+			}
+			ketty.GenerateFile(gen, file, opts)
+		}
+		return nil
+	})
+}
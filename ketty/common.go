@@ -0,0 +1,36 @@
+package ketty
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Shared helpers used by both the legacy in-process generator (ketty.go,
+// built with -tags legacy) and the standalone protogen-driven generator
+// (genketty.go and friends, the default build). This file carries no build
+// tag and must not import anything from protoc-gen-go's internal generator
+// package, so that cmd/protoc-gen-ketty can link without it.
+
+// kettyPkgPath is the import path of the ketty runtime package that
+// generated code references.
+const kettyPkgPath = "github.com/yyzybb537/ketty"
+
+// kettyOptions holds the resolved value of every ketty-specific message
+// option, populated by getKettyOptions (legacy) or getKettyMessageOptions
+// (protogen).
+type kettyOptions struct {
+	isUseKettyHttpExtend bool
+	transport            string
+	marshal              string
+	histogramBuckets     []float64
+}
+
+func floatSliceLiteral(buckets []float64) string {
+	parts := make([]string, len(buckets))
+	for i, b := range buckets {
+		parts[i] = strconv.FormatFloat(b, 'g', -1, 64)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func unexport(s string) string { return strings.ToLower(s[:1]) + s[1:] }
@@ -0,0 +1,222 @@
+package ketty
+
+import (
+	"strconv"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+)
+
+// httpBinding is one google.api.http rule resolved off a method: either its
+// primary rule or one of its additional_bindings.
+type httpBinding struct {
+	httpMethod string // GET, POST, PUT, DELETE, PATCH
+	path       string
+	body       string // "", "*", or a request field name
+}
+
+// getHTTPBindings returns every http.HttpRule binding declared on method via
+// the google.api.http method option, primary rule first, followed by its
+// additional_bindings in order. It returns nil if the method has none.
+func getHTTPBindings(method *protogen.Method) []httpBinding {
+	opts := method.Desc.Options()
+	if opts == nil {
+		return nil
+	}
+	rule, ok := proto.GetExtension(opts, annotations.E_Http).(*annotations.HttpRule)
+	if !ok || rule == nil {
+		return nil
+	}
+
+	var bindings []httpBinding
+	if b, ok := bindingFromRule(rule); ok {
+		bindings = append(bindings, b)
+	}
+	for _, additional := range rule.GetAdditionalBindings() {
+		if b, ok := bindingFromRule(additional); ok {
+			bindings = append(bindings, b)
+		}
+	}
+	return bindings
+}
+
+func bindingFromRule(rule *annotations.HttpRule) (httpBinding, bool) {
+	switch pattern := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		return httpBinding{"GET", pattern.Get, rule.GetBody()}, true
+	case *annotations.HttpRule_Post:
+		return httpBinding{"POST", pattern.Post, rule.GetBody()}, true
+	case *annotations.HttpRule_Put:
+		return httpBinding{"PUT", pattern.Put, rule.GetBody()}, true
+	case *annotations.HttpRule_Delete:
+		return httpBinding{"DELETE", pattern.Delete, rule.GetBody()}, true
+	case *annotations.HttpRule_Patch:
+		return httpBinding{"PATCH", pattern.Patch, rule.GetBody()}, true
+	}
+	return httpBinding{}, false
+}
+
+// pathParamFields extracts the ordered field paths named by a path template's
+// {field} / {field=pattern} segments, e.g. "/v1/users/{user_id}/books/{book.id=shelves/*/books/*}"
+// yields []string{"user_id", "book.id"}.
+func pathParamFields(template string) []string {
+	var fields []string
+	for {
+		start := strings.IndexByte(template, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(template[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+		spec := template[start+1 : end]
+		if eq := strings.IndexByte(spec, '='); eq >= 0 {
+			spec = spec[:eq]
+		}
+		fields = append(fields, spec)
+		template = template[end+1:]
+	}
+	return fields
+}
+
+// genkHTTPGateway emits RegisterKettyXxxHandler, which wires every
+// google.api.http annotated method of service into an HTTP/JSON gateway on
+// top of the already-generated KettyXxxClient. Methods without a
+// google.api.http option are left untouched; if none of them have one, no
+// handler function is emitted at all.
+func genkHTTPGateway(g *protogen.GeneratedFile, kettyPkg protogen.GoImportPath, service *protogen.Service) {
+	servName := service.GoName
+
+	type binding struct {
+		method *protogen.Method
+		http   httpBinding
+	}
+	var bindings []binding
+	for _, method := range service.Methods {
+		for _, b := range getHTTPBindings(method) {
+			bindings = append(bindings, binding{method, b})
+		}
+	}
+	if len(bindings) == 0 {
+		return
+	}
+
+	httpPkg := protogen.GoImportPath("net/http")
+
+	g.P("// RegisterKetty", servName, "Handler registers the google.api.http annotated")
+	g.P("// methods of ", servName, " as routes on mux, dispatching through client.")
+	g.P("func RegisterKetty", servName, "Handler(mux ", g.QualifiedGoIdent(kettyPkg.Ident("ServeMux")), ", client Ketty", servName, "Client) {")
+	for _, b := range bindings {
+		genkHTTPBinding(g, kettyPkg, httpPkg, b.method, b.http)
+	}
+	g.P("}")
+	g.P()
+}
+
+func genkHTTPBinding(g *protogen.GeneratedFile, kettyPkg, httpPkg protogen.GoImportPath, method *protogen.Method, b httpBinding) {
+	methName := method.GoName
+	inType := g.QualifiedGoIdent(method.Input.GoIdent)
+
+	isClientStream := method.Desc.IsStreamingClient()
+	pathFields := pathParamFields(b.path)
+
+	g.P("mux.Handle(", strconv.Quote(b.httpMethod), ", ", strconv.Quote(b.path), ", func(w ", g.QualifiedGoIdent(httpPkg.Ident("ResponseWriter")), ", r *", g.QualifiedGoIdent(httpPkg.Ident("Request")), ", pathParams map[string]string) {")
+	g.P("ctx := r.Context()")
+	g.P("in := new(", inType, ")")
+
+	for _, field := range pathFields {
+		g.P("if v, ok := pathParams[", strconv.Quote(field), "]; ok {")
+		g.P("if err := ", g.QualifiedGoIdent(kettyPkg.Ident("PopulateFieldFromPath")), "(in, ", strconv.Quote(field), ", v); err != nil {")
+		g.P(g.QualifiedGoIdent(httpPkg.Ident("Error")), "(w, err.Error(), ", g.QualifiedGoIdent(httpPkg.Ident("StatusBadRequest")), ")")
+		g.P("return")
+		g.P("}")
+		g.P("}")
+	}
+
+	// For client-streaming and bidi methods, r.Body is the NDJSON request
+	// stream itself and is read exactly once, below, via PumpNDJSONRequests;
+	// unmarshaling or populating in from it here would both misinterpret the
+	// framing and leave nothing for PumpNDJSONRequests to read.
+	if !isClientStream {
+		switch b.body {
+		case "":
+			g.P("if err := ", g.QualifiedGoIdent(kettyPkg.Ident("PopulateQueryParameters")), "(in, r.URL.Query()); err != nil {")
+			g.P(g.QualifiedGoIdent(httpPkg.Ident("Error")), "(w, err.Error(), ", g.QualifiedGoIdent(httpPkg.Ident("StatusBadRequest")), ")")
+			g.P("return")
+			g.P("}")
+		case "*":
+			g.P("if err := ", g.QualifiedGoIdent(kettyPkg.Ident("UnmarshalMessage")), "(r.Body, in); err != nil {")
+			g.P(g.QualifiedGoIdent(httpPkg.Ident("Error")), "(w, err.Error(), ", g.QualifiedGoIdent(httpPkg.Ident("StatusBadRequest")), ")")
+			g.P("return")
+			g.P("}")
+		default:
+			g.P("if err := ", g.QualifiedGoIdent(kettyPkg.Ident("PopulateFieldFromBody")), "(in, ", strconv.Quote(b.body), ", r.Body); err != nil {")
+			g.P(g.QualifiedGoIdent(httpPkg.Ident("Error")), "(w, err.Error(), ", g.QualifiedGoIdent(httpPkg.Ident("StatusBadRequest")), ")")
+			g.P("return")
+			g.P("}")
+		}
+	}
+
+	switch {
+	case method.Desc.IsStreamingServer() && method.Desc.IsStreamingClient():
+		g.P("stream, err := client.", methName, "(ctx)")
+		g.P("if err != nil {")
+		g.P(g.QualifiedGoIdent(httpPkg.Ident("Error")), "(w, err.Error(), ", g.QualifiedGoIdent(httpPkg.Ident("StatusInternalServerError")), ")")
+		g.P("return")
+		g.P("}")
+		if len(pathFields) > 0 {
+			g.P("if err := stream.Send(in); err != nil {")
+			g.P(g.QualifiedGoIdent(httpPkg.Ident("Error")), "(w, err.Error(), ", g.QualifiedGoIdent(httpPkg.Ident("StatusInternalServerError")), ")")
+			g.P("return")
+			g.P("}")
+		}
+		g.P("go ", g.QualifiedGoIdent(kettyPkg.Ident("PumpNDJSONRequests")), "(r.Body, stream)")
+		g.P(g.QualifiedGoIdent(kettyPkg.Ident("ServeNDJSONStream")), "(w, stream)")
+	case method.Desc.IsStreamingServer():
+		g.P("stream, err := client.", methName, "(ctx, in)")
+		g.P("if err != nil {")
+		g.P(g.QualifiedGoIdent(httpPkg.Ident("Error")), "(w, err.Error(), ", g.QualifiedGoIdent(httpPkg.Ident("StatusInternalServerError")), ")")
+		g.P("return")
+		g.P("}")
+		g.P(g.QualifiedGoIdent(kettyPkg.Ident("ServeNDJSONStream")), "(w, stream)")
+	case method.Desc.IsStreamingClient():
+		g.P("stream, err := client.", methName, "(ctx)")
+		g.P("if err != nil {")
+		g.P(g.QualifiedGoIdent(httpPkg.Ident("Error")), "(w, err.Error(), ", g.QualifiedGoIdent(httpPkg.Ident("StatusInternalServerError")), ")")
+		g.P("return")
+		g.P("}")
+		if len(pathFields) > 0 {
+			g.P("if err := stream.Send(in); err != nil {")
+			g.P(g.QualifiedGoIdent(httpPkg.Ident("Error")), "(w, err.Error(), ", g.QualifiedGoIdent(httpPkg.Ident("StatusInternalServerError")), ")")
+			g.P("return")
+			g.P("}")
+		}
+		g.P("if err := ", g.QualifiedGoIdent(kettyPkg.Ident("PumpNDJSONRequests")), "(r.Body, stream); err != nil {")
+		g.P(g.QualifiedGoIdent(httpPkg.Ident("Error")), "(w, err.Error(), ", g.QualifiedGoIdent(httpPkg.Ident("StatusBadRequest")), ")")
+		g.P("return")
+		g.P("}")
+		g.P("out, err := stream.CloseAndRecv()")
+		g.P("if err != nil {")
+		g.P(g.QualifiedGoIdent(httpPkg.Ident("Error")), "(w, err.Error(), ", g.QualifiedGoIdent(httpPkg.Ident("StatusInternalServerError")), ")")
+		g.P("return")
+		g.P("}")
+		g.P("if err := ", g.QualifiedGoIdent(kettyPkg.Ident("MarshalMessage")), "(w, out); err != nil {")
+		g.P(g.QualifiedGoIdent(httpPkg.Ident("Error")), "(w, err.Error(), ", g.QualifiedGoIdent(httpPkg.Ident("StatusInternalServerError")), ")")
+		g.P("}")
+	default:
+		g.P("out, err := client.", methName, "(ctx, in)")
+		g.P("if err != nil {")
+		g.P(g.QualifiedGoIdent(httpPkg.Ident("Error")), "(w, err.Error(), ", g.QualifiedGoIdent(httpPkg.Ident("StatusInternalServerError")), ")")
+		g.P("return")
+		g.P("}")
+		g.P("if err := ", g.QualifiedGoIdent(kettyPkg.Ident("MarshalMessage")), "(w, out); err != nil {")
+		g.P(g.QualifiedGoIdent(httpPkg.Ident("Error")), "(w, err.Error(), ", g.QualifiedGoIdent(httpPkg.Ident("StatusInternalServerError")), ")")
+		g.P("}")
+	}
+
+	g.P("})")
+}
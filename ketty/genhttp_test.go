@@ -0,0 +1,101 @@
+package ketty
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+)
+
+func TestPathParamFields(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+		want     []string
+	}{
+		{"no params", "/v1/widgets", nil},
+		{"single param", "/v1/users/{user_id}", []string{"user_id"}},
+		{
+			"multiple params",
+			"/v1/users/{user_id}/books/{book_id}",
+			[]string{"user_id", "book_id"},
+		},
+		{
+			"param with pattern and nested field",
+			"/v1/users/{user_id}/books/{book.id=shelves/*/books/*}",
+			[]string{"user_id", "book.id"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := pathParamFields(c.template)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("pathParamFields(%q) = %v, want %v", c.template, got, c.want)
+			}
+		})
+	}
+}
+
+func TestBindingFromRule(t *testing.T) {
+	cases := []struct {
+		name string
+		rule *annotations.HttpRule
+		want httpBinding
+		ok   bool
+	}{
+		{
+			name: "get",
+			rule: &annotations.HttpRule{Pattern: &annotations.HttpRule_Get{Get: "/v1/widgets/{id}"}},
+			want: httpBinding{httpMethod: "GET", path: "/v1/widgets/{id}"},
+			ok:   true,
+		},
+		{
+			name: "post with body",
+			rule: &annotations.HttpRule{
+				Pattern: &annotations.HttpRule_Post{Post: "/v1/widgets"},
+				Body:    "*",
+			},
+			want: httpBinding{httpMethod: "POST", path: "/v1/widgets", body: "*"},
+			ok:   true,
+		},
+		{
+			name: "put with named body field",
+			rule: &annotations.HttpRule{
+				Pattern: &annotations.HttpRule_Put{Put: "/v1/widgets/{id}"},
+				Body:    "widget",
+			},
+			want: httpBinding{httpMethod: "PUT", path: "/v1/widgets/{id}", body: "widget"},
+			ok:   true,
+		},
+		{
+			name: "delete",
+			rule: &annotations.HttpRule{Pattern: &annotations.HttpRule_Delete{Delete: "/v1/widgets/{id}"}},
+			want: httpBinding{httpMethod: "DELETE", path: "/v1/widgets/{id}"},
+			ok:   true,
+		},
+		{
+			name: "patch",
+			rule: &annotations.HttpRule{Pattern: &annotations.HttpRule_Patch{Patch: "/v1/widgets/{id}"}},
+			want: httpBinding{httpMethod: "PATCH", path: "/v1/widgets/{id}"},
+			ok:   true,
+		},
+		{
+			name: "custom pattern is unsupported",
+			rule: &annotations.HttpRule{Pattern: &annotations.HttpRule_Custom{Custom: &annotations.CustomHttpPattern{}}},
+			ok:   false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, ok := bindingFromRule(c.rule)
+			if ok != c.ok {
+				t.Fatalf("bindingFromRule() ok = %v, want %v", ok, c.ok)
+			}
+			if ok && got != c.want {
+				t.Errorf("bindingFromRule() = %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
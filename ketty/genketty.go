@@ -0,0 +1,448 @@
+package ketty
+
+import (
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+
+	kettyProto "github.com/yyzybb537/protoc-gen-ketty/include"
+)
+
+// kettyFileSuffix is the suffix appended to the proto file's base name to
+// produce the name of the standalone ketty file, e.g. "foo.proto" ->
+// "foo_ketty.pb.go", mirroring protoc-gen-go-grpc's "_grpc.pb.go".
+const kettyFileSuffix = "_ketty.pb.go"
+
+// contextPackage is resolved through g.QualifiedGoIdent so the generated file
+// picks up whatever local alias (if any) the import needs.
+var contextPackage = protogen.GoImportPath("context")
+
+// Options controls which optional generation modes GenerateFile runs, driven
+// by protoc-gen-ketty's own command-line parameters (e.g. "proxy=true").
+type Options struct {
+	// Proxy additionally emits a RegisterKettyXxxProxy per service: a
+	// transparent, payload-agnostic forwarder driven by a StreamDirector.
+	Proxy bool
+	// Metrics, when "prometheus", wraps every unary client/server method with
+	// a per-service Prometheus collector (see genmetrics.go). Streaming
+	// methods are not instrumented.
+	Metrics string
+}
+
+// GenerateFile generates the ketty bindings for a single proto file into its
+// own output file, independent of protoc-gen-go's own *.pb.go. It is the
+// standalone-binary (protogen) counterpart of generateService/
+// generateOptionMethods in ketty.go, which drive the same generation in-process
+// from protoc-gen-go when built with the "legacy" tag.
+func GenerateFile(gen *protogen.Plugin, file *protogen.File, opts Options) *protogen.GeneratedFile {
+	if len(file.Services) == 0 && !anyMessageHasKettyOptions(file) {
+		return nil
+	}
+
+	filename := strings.TrimSuffix(file.GeneratedFilenamePrefix, ".pb.go") + kettyFileSuffix
+	g := gen.NewGeneratedFile(filename, file.GoImportPath)
+
+	g.P("// Code generated by protoc-gen-ketty. DO NOT EDIT.")
+	g.P("// source: ", file.Desc.Path())
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+
+	kettyPkg := protogen.GoImportPath(kettyPkgPath)
+	g.P("// Reference imports to suppress errors if they are not otherwise used.")
+	g.P("var _ ", g.QualifiedGoIdent(kettyPkg.Ident("Dummy")))
+	g.P()
+
+	for _, service := range file.Services {
+		genkService(g, kettyPkg, file, service, opts.Metrics == "prometheus")
+		genkHTTPGateway(g, kettyPkg, service)
+		if opts.Proxy {
+			genkProxy(g, kettyPkg, file, service)
+		}
+	}
+
+	for _, message := range file.Messages {
+		genkOptionMethods(g, message)
+	}
+
+	return g
+}
+
+func anyMessageHasKettyOptions(file *protogen.File) bool {
+	for _, message := range file.Messages {
+		opts := getKettyMessageOptions(message)
+		if opts.isUseKettyHttpExtend || opts.marshal != "" || opts.transport != "" || len(opts.histogramBuckets) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func getKettyMessageOptions(message *protogen.Message) *kettyOptions {
+	opts := &kettyOptions{}
+	msgOpts := message.Desc.Options()
+	if msgOpts == nil {
+		return opts
+	}
+
+	if v := proto.GetExtension(msgOpts, kettyProto.E_UseKettyHttpExtend); v != nil {
+		if b, ok := v.(*bool); ok && b != nil {
+			opts.isUseKettyHttpExtend = *b
+		}
+	}
+	if v := proto.GetExtension(msgOpts, kettyProto.E_Transport); v != nil {
+		if s, ok := v.(*string); ok && s != nil {
+			opts.transport = *s
+		}
+	}
+	if v := proto.GetExtension(msgOpts, kettyProto.E_Marshal); v != nil {
+		if s, ok := v.(*string); ok && s != nil {
+			opts.marshal = *s
+		}
+	}
+	if v := proto.GetExtension(msgOpts, kettyProto.E_HistogramBuckets); v != nil {
+		if buckets, ok := v.([]float64); ok {
+			opts.histogramBuckets = buckets
+		}
+	}
+	return opts
+}
+
+func genkOptionMethods(g *protogen.GeneratedFile, message *protogen.Message) {
+	opts := getKettyMessageOptions(message)
+	name := message.GoIdent.GoName
+
+	if opts.isUseKettyHttpExtend {
+		g.P("func (*", name, ") KettyHttpExtendMessage() {}")
+		g.P()
+	}
+	if opts.marshal != "" {
+		g.P("func (*", name, ") KettyMarshal() string {")
+		g.P("return ", strconv.Quote(opts.marshal))
+		g.P("}")
+		g.P()
+	}
+	if opts.transport != "" {
+		g.P("func (*", name, ") KettyTransport() string {")
+		g.P("return ", strconv.Quote(opts.transport))
+		g.P("}")
+		g.P()
+	}
+	if len(opts.histogramBuckets) > 0 {
+		g.P("func (*", name, ") KettyHistogramBuckets() []float64 {")
+		g.P("return []float64{", floatSliceLiteral(opts.histogramBuckets), "}")
+		g.P("}")
+		g.P()
+	}
+}
+
+func genkService(g *protogen.GeneratedFile, kettyPkg protogen.GoImportPath, file *protogen.File, service *protogen.Service, metricsEnabled bool) {
+	servName := service.GoName
+	fullServName := string(service.Desc.FullName())
+	serviceDescVar := "_" + servName + "_serviceDesc"
+
+	var metricsVar string
+	if metricsEnabled {
+		metricsVar = genkMetricsCollector(g, kettyPkg, servName, service)
+	}
+
+	handleT := servName + "HandleT"
+	g.P("type ", handleT, " struct {")
+	g.P("desc *", g.QualifiedGoIdent(kettyPkg.Ident("ServiceDesc")))
+	g.P("}")
+	g.P()
+
+	g.P("func (h *", handleT, ") Implement() interface{} {")
+	g.P("return h.desc")
+	g.P("}")
+	g.P()
+
+	g.P("func (h *", handleT, ") ServiceName() string {")
+	g.P("return h.desc.ServiceName")
+	g.P("}")
+	g.P()
+
+	g.P("var ", servName, "Handle = &", handleT, "{ desc: &", serviceDescVar, " }")
+	g.P()
+
+	g.P("type Ketty", servName, "Client struct {")
+	g.P("client ", g.QualifiedGoIdent(kettyPkg.Ident("Client")))
+	g.P("}")
+	g.P()
+
+	g.P("func NewKetty", servName, "Client(client ", g.QualifiedGoIdent(kettyPkg.Ident("Client")), ") *Ketty", servName, "Client {")
+	g.P("return &Ketty", servName, "Client{client}")
+	g.P("}")
+	g.P()
+
+	var methodIndex, streamIndex int
+	for _, method := range service.Methods {
+		var descExpr string
+		if !method.Desc.IsStreamingServer() && !method.Desc.IsStreamingClient() {
+			descExpr = serviceDescVar + ".Methods[" + strconv.Itoa(methodIndex) + "]"
+			methodIndex++
+		} else {
+			descExpr = serviceDescVar + ".Streams[" + strconv.Itoa(streamIndex) + "]"
+			streamIndex++
+		}
+		genkClientMethod(g, kettyPkg, servName, method, "&"+descExpr, metricsVar)
+	}
+
+	g.P("// Ketty", servName, "Server is the server API for ", servName, " service.")
+	g.P("type Ketty", servName, "Server interface {")
+	for _, method := range service.Methods {
+		g.P(genkServerSignature(g, kettyPkg, servName, method))
+	}
+	g.P("}")
+	g.P()
+
+	g.P("func RegisterKetty", servName, "Server(s ", g.QualifiedGoIdent(kettyPkg.Ident("Server")), ", srv Ketty", servName, "Server) {")
+	g.P("s.RegisterService(&", serviceDescVar, ", srv)")
+	g.P("}")
+	g.P()
+
+	for _, method := range service.Methods {
+		genkServerMethod(g, kettyPkg, servName, method, metricsVar)
+	}
+
+	genkServiceDescriptor(g, kettyPkg, file, servName, fullServName, service)
+}
+
+func genkClientSignature(g *protogen.GeneratedFile, servName string, method *protogen.Method) string {
+	methName := method.GoName
+	reqArg := ", in *" + g.QualifiedGoIdent(method.Input.GoIdent)
+	if method.Desc.IsStreamingClient() {
+		reqArg = ""
+	}
+	respName := "*" + g.QualifiedGoIdent(method.Output.GoIdent)
+	if method.Desc.IsStreamingServer() || method.Desc.IsStreamingClient() {
+		respName = servName + "_" + methName + "Client"
+	}
+	return methName + "(ctx " + g.QualifiedGoIdent(contextPackage.Ident("Context")) + reqArg + ") (" + respName + ", error)"
+}
+
+func genkClientMethod(g *protogen.GeneratedFile, kettyPkg protogen.GoImportPath, servName string, method *protogen.Method, descExpr string, metricsVar string) {
+	methName := method.GoName
+	inType := g.QualifiedGoIdent(method.Input.GoIdent)
+	outType := g.QualifiedGoIdent(method.Output.GoIdent)
+
+	g.P("func (this *Ketty", servName, "Client) ", genkClientSignature(g, servName, method), " {")
+	if !method.Desc.IsStreamingServer() && !method.Desc.IsStreamingClient() {
+		g.P("out := new(", outType, ")")
+		if metricsVar != "" {
+			g.P("start := ", g.QualifiedGoIdent(timePackage.Ident("Now")), "()")
+		}
+		g.P("err := this.client.Invoke(ctx, ", servName, "Handle, ", strconv.Quote(methName), ", in, out, this.client.CallOptions()...)")
+		if metricsVar != "" {
+			g.P(metricsVar, ".Counter.WithLabelValues(", strconv.Quote(methName), ", ", g.QualifiedGoIdent(kettyPkg.Ident("StatusCode")), "(err)).Inc()")
+			g.P(metricsVar, ".Histogram.WithLabelValues(", strconv.Quote(methName), ").Observe(", g.QualifiedGoIdent(timePackage.Ident("Since")), "(start).Seconds())")
+		}
+		g.P("if err != nil { return nil, err }")
+		g.P("return out, nil")
+		g.P("}")
+		g.P()
+		return
+	}
+
+	streamType := unexport(servName) + methName + "Client"
+	g.P("stream, err := this.client.NewStream(ctx, ", descExpr, ", ", servName, "Handle, ", strconv.Quote(methName), ")")
+	g.P("if err != nil { return nil, err }")
+	g.P("x := &", streamType, "{stream}")
+	if !method.Desc.IsStreamingClient() {
+		g.P("if err := x.ClientStream.SendMsg(in); err != nil { return nil, err }")
+		g.P("if err := x.ClientStream.CloseSend(); err != nil { return nil, err }")
+	}
+	g.P("return x, nil")
+	g.P("}")
+	g.P()
+
+	genSend := method.Desc.IsStreamingClient()
+	genRecv := method.Desc.IsStreamingServer()
+	genCloseAndRecv := !method.Desc.IsStreamingServer()
+
+	g.P("type ", servName, "_", methName, "Client interface {")
+	if genSend {
+		g.P("Send(*", inType, ") error")
+	}
+	if genRecv {
+		g.P("Recv() (*", outType, ", error)")
+	}
+	if genCloseAndRecv {
+		g.P("CloseAndRecv() (*", outType, ", error)")
+	}
+	g.P(g.QualifiedGoIdent(kettyPkg.Ident("ClientStream")))
+	g.P("}")
+	g.P()
+
+	g.P("type ", streamType, " struct {")
+	g.P(g.QualifiedGoIdent(kettyPkg.Ident("ClientStream")))
+	g.P("}")
+	g.P()
+
+	if genSend {
+		g.P("func (x *", streamType, ") Send(m *", inType, ") error {")
+		g.P("return x.ClientStream.SendMsg(m)")
+		g.P("}")
+		g.P()
+	}
+	if genRecv {
+		g.P("func (x *", streamType, ") Recv() (*", outType, ", error) {")
+		g.P("m := new(", outType, ")")
+		g.P("if err := x.ClientStream.RecvMsg(m); err != nil { return nil, err }")
+		g.P("return m, nil")
+		g.P("}")
+		g.P()
+	}
+	if genCloseAndRecv {
+		g.P("func (x *", streamType, ") CloseAndRecv() (*", outType, ", error) {")
+		g.P("m := new(", outType, ")")
+		g.P("if err := x.ClientStream.RecvMsg(m); err != nil { return nil, err }")
+		g.P("return m, nil")
+		g.P("}")
+		g.P()
+	}
+}
+
+func genkServerSignature(g *protogen.GeneratedFile, kettyPkg protogen.GoImportPath, servName string, method *protogen.Method) string {
+	methName := method.GoName
+
+	var reqArgs []string
+	ret := "error"
+	if !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer() {
+		reqArgs = append(reqArgs, g.QualifiedGoIdent(contextPackage.Ident("Context")))
+	}
+	if !method.Desc.IsStreamingClient() {
+		reqArgs = append(reqArgs, "*"+g.QualifiedGoIdent(method.Input.GoIdent))
+	}
+	if method.Desc.IsStreamingServer() || method.Desc.IsStreamingClient() {
+		reqArgs = append(reqArgs, servName+"_"+methName+"Server")
+	}
+	if !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer() {
+		ret = "(*" + g.QualifiedGoIdent(method.Output.GoIdent) + ", error)"
+	}
+
+	return methName + "(" + strings.Join(reqArgs, ", ") + ") " + ret
+}
+
+func genkServerMethod(g *protogen.GeneratedFile, kettyPkg protogen.GoImportPath, servName string, method *protogen.Method, metricsVar string) {
+	methName := method.GoName
+	hname := "_" + servName + "_" + methName + "_Handler"
+	inType := g.QualifiedGoIdent(method.Input.GoIdent)
+	outType := g.QualifiedGoIdent(method.Output.GoIdent)
+
+	if !method.Desc.IsStreamingServer() && !method.Desc.IsStreamingClient() {
+		g.P("func ", hname, "(srv interface{}, ctx ", g.QualifiedGoIdent(contextPackage.Ident("Context")), ", dec func(interface{}) error) (interface{}, error) {")
+		g.P("in := new(", inType, ")")
+		g.P("if err := dec(in); err != nil { return nil, err }")
+		if metricsVar == "" {
+			g.P("return srv.(Ketty", servName, "Server).", methName, "(ctx, in)")
+			g.P("}")
+			g.P()
+			return
+		}
+		g.P("start := ", g.QualifiedGoIdent(timePackage.Ident("Now")), "()")
+		g.P("out, err := srv.(Ketty", servName, "Server).", methName, "(ctx, in)")
+		g.P(metricsVar, ".Counter.WithLabelValues(", strconv.Quote(methName), ", ", g.QualifiedGoIdent(kettyPkg.Ident("StatusCode")), "(err)).Inc()")
+		g.P(metricsVar, ".Histogram.WithLabelValues(", strconv.Quote(methName), ").Observe(", g.QualifiedGoIdent(timePackage.Ident("Since")), "(start).Seconds())")
+		g.P("return out, err")
+		g.P("}")
+		g.P()
+		return
+	}
+
+	streamType := unexport(servName) + methName + "Server"
+	g.P("func ", hname, "(srv interface{}, stream ", g.QualifiedGoIdent(kettyPkg.Ident("ServerStream")), ") error {")
+	if !method.Desc.IsStreamingClient() {
+		g.P("m := new(", inType, ")")
+		g.P("if err := stream.RecvMsg(m); err != nil { return err }")
+		g.P("return srv.(Ketty", servName, "Server).", methName, "(m, &", streamType, "{stream})")
+	} else {
+		g.P("return srv.(Ketty", servName, "Server).", methName, "(&", streamType, "{stream})")
+	}
+	g.P("}")
+	g.P()
+
+	genSend := method.Desc.IsStreamingServer()
+	genSendAndClose := !method.Desc.IsStreamingServer()
+	genRecv := method.Desc.IsStreamingClient()
+
+	g.P("type ", servName, "_", methName, "Server interface {")
+	if genSend {
+		g.P("Send(*", outType, ") error")
+	}
+	if genSendAndClose {
+		g.P("SendAndClose(*", outType, ") error")
+	}
+	if genRecv {
+		g.P("Recv() (*", inType, ", error)")
+	}
+	g.P(g.QualifiedGoIdent(kettyPkg.Ident("ServerStream")))
+	g.P("}")
+	g.P()
+
+	g.P("type ", streamType, " struct {")
+	g.P(g.QualifiedGoIdent(kettyPkg.Ident("ServerStream")))
+	g.P("}")
+	g.P()
+
+	if genSend {
+		g.P("func (x *", streamType, ") Send(m *", outType, ") error {")
+		g.P("return x.ServerStream.SendMsg(m)")
+		g.P("}")
+		g.P()
+	}
+	if genSendAndClose {
+		g.P("func (x *", streamType, ") SendAndClose(m *", outType, ") error {")
+		g.P("return x.ServerStream.SendMsg(m)")
+		g.P("}")
+		g.P()
+	}
+	if genRecv {
+		g.P("func (x *", streamType, ") Recv() (*", inType, ", error) {")
+		g.P("m := new(", inType, ")")
+		g.P("if err := x.ServerStream.RecvMsg(m); err != nil { return nil, err }")
+		g.P("return m, nil")
+		g.P("}")
+		g.P()
+	}
+}
+
+func genkServiceDescriptor(g *protogen.GeneratedFile, kettyPkg protogen.GoImportPath, file *protogen.File, servName, fullServName string, service *protogen.Service) {
+	serviceDescVar := "_" + servName + "_serviceDesc"
+
+	g.P("var ", serviceDescVar, " = ", g.QualifiedGoIdent(kettyPkg.Ident("ServiceDesc")), "{")
+	g.P("ServiceName: ", strconv.Quote(fullServName), ",")
+	g.P("HandlerType: (*Ketty", servName, "Server)(nil),")
+
+	g.P("Methods: []", g.QualifiedGoIdent(kettyPkg.Ident("MethodDesc")), "{")
+	for _, method := range service.Methods {
+		if method.Desc.IsStreamingServer() || method.Desc.IsStreamingClient() {
+			continue
+		}
+		g.P("{")
+		g.P("MethodName: ", strconv.Quote(method.GoName), ",")
+		g.P("Handler: _", servName, "_", method.GoName, "_Handler,")
+		g.P("},")
+	}
+	g.P("},")
+
+	g.P("Streams: []", g.QualifiedGoIdent(kettyPkg.Ident("StreamDesc")), "{")
+	for _, method := range service.Methods {
+		if !method.Desc.IsStreamingServer() && !method.Desc.IsStreamingClient() {
+			continue
+		}
+		g.P("{")
+		g.P("StreamName: ", strconv.Quote(method.GoName), ",")
+		g.P("Handler: _", servName, "_", method.GoName, "_Handler,")
+		g.P("ServerStreams: ", strconv.FormatBool(method.Desc.IsStreamingServer()), ",")
+		g.P("ClientStreams: ", strconv.FormatBool(method.Desc.IsStreamingClient()), ",")
+		g.P("},")
+	}
+	g.P("},")
+
+	g.P("Metadata: ", strconv.Quote(file.Desc.Path()), ",")
+	g.P("}")
+	g.P()
+}
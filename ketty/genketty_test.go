@@ -0,0 +1,99 @@
+package ketty
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// buildStreamingTestService compiles a single-method "Svc.Do(Req) Resp"
+// service with the given streaming flags through protogen, and returns a
+// *protogen.GeneratedFile (for QualifiedGoIdent) alongside the method.
+func buildStreamingTestService(t *testing.T, clientStreaming, serverStreaming bool) (*protogen.GeneratedFile, *protogen.Method) {
+	t.Helper()
+
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		Options: &descriptorpb.FileOptions{GoPackage: proto.String("example.com/test")},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Req")},
+			{Name: proto.String("Resp")},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			{
+				Name: proto.String("Svc"),
+				Method: []*descriptorpb.MethodDescriptorProto{
+					{
+						Name:            proto.String("Do"),
+						InputType:       proto.String(".test.Req"),
+						OutputType:      proto.String(".test.Resp"),
+						ClientStreaming: proto.Bool(clientStreaming),
+						ServerStreaming: proto.Bool(serverStreaming),
+					},
+				},
+			},
+		},
+	}
+
+	gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"test.proto"},
+		ProtoFile:      []*descriptorpb.FileDescriptorProto{fd},
+	})
+	if err != nil {
+		t.Fatalf("protogen.Options.New() failed: %v", err)
+	}
+
+	file := gen.FilesByPath["test.proto"]
+	g := gen.NewGeneratedFile("test_ketty.pb.go", file.GoImportPath)
+	return g, file.Services[0].Methods[0]
+}
+
+func TestGenkClientSignature(t *testing.T) {
+	cases := []struct {
+		name                             string
+		clientStreaming, serverStreaming bool
+		want                             string
+	}{
+		{"unary", false, false, "Do(ctx context.Context, in *Req) (*Resp, error)"},
+		{"client streaming", true, false, "Do(ctx context.Context) (Svc_DoClient, error)"},
+		{"server streaming", false, true, "Do(ctx context.Context, in *Req) (Svc_DoClient, error)"},
+		{"bidi streaming", true, true, "Do(ctx context.Context) (Svc_DoClient, error)"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			g, method := buildStreamingTestService(t, c.clientStreaming, c.serverStreaming)
+			if got := genkClientSignature(g, "Svc", method); got != c.want {
+				t.Errorf("genkClientSignature() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestGenkServerSignature(t *testing.T) {
+	kettyPkg := protogen.GoImportPath(kettyPkgPath)
+	cases := []struct {
+		name                             string
+		clientStreaming, serverStreaming bool
+		want                             string
+	}{
+		{"unary", false, false, "Do(context.Context, *Req) (*Resp, error)"},
+		{"client streaming", true, false, "Do(Svc_DoServer) error"},
+		{"server streaming", false, true, "Do(*Req, Svc_DoServer) error"},
+		{"bidi streaming", true, true, "Do(Svc_DoServer) error"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			g, method := buildStreamingTestService(t, c.clientStreaming, c.serverStreaming)
+			if got := genkServerSignature(g, kettyPkg, "Svc", method); got != c.want {
+				t.Errorf("genkServerSignature() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
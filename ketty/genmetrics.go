@@ -0,0 +1,62 @@
+package ketty
+
+import (
+	"strconv"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// timePackage and prometheusPackage are resolved through g.QualifiedGoIdent so
+// the generated file picks up whatever local alias (if any) each import needs.
+var (
+	timePackage       = protogen.GoImportPath("time")
+	prometheusPackage = protogen.GoImportPath("github.com/prometheus/client_golang/prometheus")
+)
+
+// genkMetricsCollector emits the per-service Prometheus collector that the
+// metrics-wrapped client and server methods in genkClientMethod/
+// genkServerMethod record against, plus a RegisterKettyXxxMetrics helper so
+// callers can attach it to their own prometheus.Registerer. It returns the
+// collector's variable name. Only runs when Options.Metrics == "prometheus".
+func genkMetricsCollector(g *protogen.GeneratedFile, kettyPkg protogen.GoImportPath, servName string, service *protogen.Service) string {
+	metricsVar := "_" + servName + "_metrics"
+
+	bucketsExpr := "nil"
+	if buckets := serviceHistogramBuckets(service); len(buckets) > 0 {
+		bucketsExpr = "[]float64{" + floatSliceLiteral(buckets) + "}"
+	}
+
+	g.P("var ", metricsVar, " = ", g.QualifiedGoIdent(kettyPkg.Ident("NewPrometheusCollector")), "(", strconv.Quote(servName), ", []string{")
+	for _, method := range service.Methods {
+		g.P(strconv.Quote(method.GoName), ",")
+	}
+	g.P("}, ", bucketsExpr, ")")
+	g.P()
+
+	g.P("// RegisterKetty", servName, "Metrics registers ", servName, "'s collector on reg.")
+	g.P("func RegisterKetty", servName, "Metrics(reg ", g.QualifiedGoIdent(prometheusPackage.Ident("Registerer")), ") error {")
+	g.P("return reg.Register(", metricsVar, ")")
+	g.P("}")
+	g.P()
+
+	return metricsVar
+}
+
+// serviceHistogramBuckets returns the histogram buckets configured via the
+// "ketty_histogram_buckets" message option on the first of service's
+// request/response messages that sets one, or nil if none do.
+func serviceHistogramBuckets(service *protogen.Service) []float64 {
+	seen := map[*protogen.Message]bool{}
+	for _, method := range service.Methods {
+		for _, message := range []*protogen.Message{method.Input, method.Output} {
+			if seen[message] {
+				continue
+			}
+			seen[message] = true
+			if buckets := getKettyMessageOptions(message).histogramBuckets; len(buckets) > 0 {
+				return buckets
+			}
+		}
+	}
+	return nil
+}
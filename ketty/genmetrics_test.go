@@ -0,0 +1,82 @@
+package ketty
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	kettyProto "github.com/yyzybb537/protoc-gen-ketty/include"
+)
+
+// buildTestService compiles a single-method "Svc.Do(Req) Resp" service
+// through protogen, optionally setting the ketty_histogram_buckets option on
+// Resp, and returns the resulting *protogen.Service for serviceHistogramBuckets
+// to inspect.
+func buildTestService(t *testing.T, respBuckets []float64) *protogen.Service {
+	t.Helper()
+
+	var respOpts *descriptorpb.MessageOptions
+	if len(respBuckets) > 0 {
+		respOpts = &descriptorpb.MessageOptions{}
+		proto.SetExtension(respOpts, kettyProto.E_HistogramBuckets, respBuckets)
+	}
+
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		Options: &descriptorpb.FileOptions{GoPackage: proto.String("example.com/test")},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Req")},
+			{Name: proto.String("Resp"), Options: respOpts},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			{
+				Name: proto.String("Svc"),
+				Method: []*descriptorpb.MethodDescriptorProto{
+					{
+						Name:       proto.String("Do"),
+						InputType:  proto.String(".test.Req"),
+						OutputType: proto.String(".test.Resp"),
+					},
+				},
+			},
+		},
+	}
+
+	gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"test.proto"},
+		ProtoFile:      []*descriptorpb.FileDescriptorProto{fd},
+	})
+	if err != nil {
+		t.Fatalf("protogen.Options.New() failed: %v", err)
+	}
+
+	file := gen.FilesByPath["test.proto"]
+	if len(file.Services) != 1 {
+		t.Fatalf("got %d services, want 1", len(file.Services))
+	}
+	return file.Services[0]
+}
+
+func TestServiceHistogramBuckets(t *testing.T) {
+	t.Run("no option set", func(t *testing.T) {
+		service := buildTestService(t, nil)
+		if got := serviceHistogramBuckets(service); got != nil {
+			t.Errorf("serviceHistogramBuckets() = %v, want nil", got)
+		}
+	})
+
+	t.Run("option set on response message", func(t *testing.T) {
+		want := []float64{0.1, 0.5, 1, 5}
+		service := buildTestService(t, want)
+		got := serviceHistogramBuckets(service)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("serviceHistogramBuckets() = %v, want %v", got, want)
+		}
+	})
+}
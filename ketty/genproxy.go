@@ -0,0 +1,132 @@
+package ketty
+
+import (
+	"strconv"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// genkProxy emits RegisterKettyXxxProxy, a transparent, payload-agnostic
+// forwarder for every method of service. Unlike genkService's client/server
+// bindings, the proxy never unmarshals the payload: every RPC - unary or
+// streaming - is registered as a bidirectional stream over a raw-bytes codec,
+// and a single shared handler pumps frames between the caller and whatever
+// backend director picks, mirroring the grpc-proxy pattern.
+func genkProxy(g *protogen.GeneratedFile, kettyPkg protogen.GoImportPath, file *protogen.File, service *protogen.Service) {
+	servName := service.GoName
+	fullServName := string(service.Desc.FullName())
+	proxyDescVar := "_" + servName + "_proxyDesc"
+	codecVar := "_" + servName + "_proxyCodec"
+	handlerName := "_" + servName + "_ProxyHandler"
+
+	ioPkg := protogen.GoImportPath("io")
+	errorsPkg := protogen.GoImportPath("errors")
+
+	g.P("// RegisterKetty", servName, "Proxy registers ", servName, " as a transparent proxy:")
+	g.P("// every method is forwarded, without unmarshaling, to the backend director picks.")
+	g.P("func RegisterKetty", servName, "Proxy(s ", g.QualifiedGoIdent(kettyPkg.Ident("Server")), ", director ", g.QualifiedGoIdent(kettyPkg.Ident("StreamDirector")), ") {")
+	g.P("s.RegisterService(&", proxyDescVar, ", director)")
+	g.P("}")
+	g.P()
+
+	g.P("var ", codecVar, " = ", g.QualifiedGoIdent(kettyPkg.Ident("Codec")), "{")
+	g.P("Marshal: func(v interface{}) ([]byte, error) { return v.([]byte), nil },")
+	g.P("Unmarshal: func(data []byte, v interface{}) error {")
+	g.P("p, ok := v.(*[]byte)")
+	g.P("if !ok {")
+	g.P("return ", g.QualifiedGoIdent(errorsPkg.Ident("New")), "(\"ketty: proxy: Unmarshal target is not *[]byte\")")
+	g.P("}")
+	g.P("*p = data")
+	g.P("return nil")
+	g.P("},")
+	g.P("}")
+	g.P()
+
+	g.P("func ", handlerName, "(srv interface{}, stream ", g.QualifiedGoIdent(kettyPkg.Ident("ServerStream")), ") error {")
+	g.P("director, ok := srv.(", g.QualifiedGoIdent(kettyPkg.Ident("StreamDirector")), ")")
+	g.P("if !ok {")
+	g.P("return ", g.QualifiedGoIdent(errorsPkg.Ident("New")), "(\"ketty: proxy: srv is not a StreamDirector\")")
+	g.P("}")
+	g.P("ctx := stream.Context()")
+	g.P("fullMethod, ok := ", g.QualifiedGoIdent(kettyPkg.Ident("FullMethodFromContext")), "(ctx)")
+	g.P("if !ok {")
+	g.P("return ", g.QualifiedGoIdent(errorsPkg.Ident("New")), "(\"ketty: proxy: full method not found in context\")")
+	g.P("}")
+	g.P()
+	g.P("outCtx, conn, err := director(ctx, fullMethod)")
+	g.P("if err != nil {")
+	g.P("return err")
+	g.P("}")
+	g.P("clientStream, err := conn.NewStream(outCtx, fullMethod, ", codecVar, ")")
+	g.P("if err != nil {")
+	g.P("return err")
+	g.P("}")
+	g.P()
+	g.P("c2s := make(chan error, 1)")
+	g.P("s2c := make(chan error, 1)")
+	g.P()
+	g.P("go func() {")
+	g.P("for {")
+	g.P("var frame []byte")
+	g.P("if err := stream.RecvMsg(&frame); err != nil {")
+	g.P("clientStream.CloseSend()")
+	g.P("c2s <- err")
+	g.P("return")
+	g.P("}")
+	g.P("if err := clientStream.SendMsg(frame); err != nil {")
+	g.P("c2s <- err")
+	g.P("return")
+	g.P("}")
+	g.P("}")
+	g.P("}()")
+	g.P()
+	g.P("go func() {")
+	g.P("for {")
+	g.P("var frame []byte")
+	g.P("if err := clientStream.RecvMsg(&frame); err != nil {")
+	g.P("s2c <- err")
+	g.P("return")
+	g.P("}")
+	g.P("if err := stream.SendMsg(frame); err != nil {")
+	g.P("s2c <- err")
+	g.P("return")
+	g.P("}")
+	g.P("}")
+	g.P("}()")
+	g.P()
+	g.P("for i := 0; i < 2; i++ {")
+	g.P("select {")
+	g.P("case err := <-c2s:")
+	g.P("if err != nil && err != ", g.QualifiedGoIdent(ioPkg.Ident("EOF")), " {")
+	g.P("stream.SetTrailer(clientStream.Trailer())")
+	g.P("return err")
+	g.P("}")
+	g.P("case err := <-s2c:")
+	g.P("if err != nil && err != ", g.QualifiedGoIdent(ioPkg.Ident("EOF")), " {")
+	g.P("stream.SetTrailer(clientStream.Trailer())")
+	g.P("return err")
+	g.P("}")
+	g.P("}")
+	g.P("}")
+	g.P("stream.SetTrailer(clientStream.Trailer())")
+	g.P("return nil")
+	g.P("}")
+	g.P()
+
+	g.P("var ", proxyDescVar, " = ", g.QualifiedGoIdent(kettyPkg.Ident("ServiceDesc")), "{")
+	g.P("ServiceName: ", strconv.Quote(fullServName), ",")
+	g.P("HandlerType: (*", g.QualifiedGoIdent(kettyPkg.Ident("StreamDirector")), ")(nil),")
+	g.P("Streams: []", g.QualifiedGoIdent(kettyPkg.Ident("StreamDesc")), "{")
+	for _, method := range service.Methods {
+		g.P("{")
+		g.P("StreamName: ", strconv.Quote(method.GoName), ",")
+		g.P("Handler: ", handlerName, ",")
+		g.P("ServerStreams: true,")
+		g.P("ClientStreams: true,")
+		g.P("},")
+	}
+	g.P("},")
+	g.P("Metadata: ", strconv.Quote(file.Desc.Path()), ",")
+	g.P("}")
+	g.P()
+}
@@ -1,3 +1,5 @@
+// +build legacy
+
 // Go support for Protocol Buffers - Google's data interchange format
 //
 // Copyright 2015 The Go Authors.  All rights reserved.
@@ -31,7 +33,13 @@
 
 // Package ketty outputs gRPC service descriptions in Go code.
 // It runs as a plugin for the Go protocol buffer compiler plugin.
-// It is linked in to protoc-gen-go.
+//
+// This file and plugin.go are only built with the "legacy" tag, which links
+// the plugin into protoc-gen-go itself; the standalone protoc-gen-ketty
+// binary (cmd/protoc-gen-ketty) drives the equivalent generateService/
+// generateOptionMethods logic from protogen instead, in genketty.go. Code
+// shared between the two, with no dependency on protoc-gen-go's internal
+// generator package, lives in common.go so it builds either way.
 package ketty
 
 import (
@@ -55,20 +63,18 @@ var _ = log.GetLog
 // a constant, ketty.SupportPackageIsVersionN (where N is generatedCodeVersion).
 const generatedCodeVersion = 4
 
-// Paths for packages used by code generated in this file,
-// relative to the import_prefix of the generator.Generator.
-const (
-	kettyPkgPath    = "github.com/yyzybb537/ketty"
-)
-
-func init() {
-	generator.RegisterPlugin(new(ketty))
-}
-
 // ketty is an implementation of the Go protocol buffer compiler's
 // plugin architecture.  It generates bindings for gRPC support.
 type ketty struct {
 	gen *generator.Generator
+
+	// metricsEnabled is set from the "metrics" plugin parameter (currently
+	// only "prometheus" is recognized) and gates the per-service collector
+	// and method instrumentation emitted by generateMetricsCollector.
+	metricsEnabled bool
+	// metricsVar is the collector variable name for the service currently
+	// being generated; valid only while generateService is running.
+	metricsVar string
 }
 
 // Name returns the name of this plugin, "ketty".
@@ -89,6 +95,7 @@ func (g *ketty) Init(gen *generator.Generator) {
 	g.gen = gen
 	contextPkg = "context"
 	kettyPkg = generator.RegisterUniquePackageName("ketty", nil)
+	g.metricsEnabled = gen.Param["metrics"] == "prometheus"
 }
 
 // Given a type name defined in a .proto, return its object.
@@ -106,7 +113,12 @@ func (g *ketty) typeName(str string) string {
 // P forwards to g.gen.P.
 func (g *ketty) P(args ...interface{}) { g.gen.P(args...) }
 
-// Generate generates code for the services in the given file.
+// Generate generates code for the services in the given file: the
+// KettyXxxClient/KettyXxxServer bindings and, when the "metrics=prometheus"
+// parameter is set, their Prometheus instrumentation. Unlike the standalone
+// protoc-gen-ketty binary (genketty.go), this legacy in-process mode does
+// not emit an HTTP/JSON gateway (genhttp.go) or a proxy stub (genproxy.go);
+// those are new, protogen-only features with no -tags legacy equivalent.
 func (g *ketty) Generate(file *generator.FileDescriptor) {
 	if len(file.FileDescriptorProto.Service) == 0 {
 		return
@@ -141,12 +153,6 @@ func (g *ketty) Generate(file *generator.FileDescriptor) {
     }
 }
 
-type kettyOptions struct {
-	isUseKettyHttpExtend bool
-	transport string
-	marshal string
-}
-
 func getKettyOptions(message *pb.DescriptorProto) (opts *kettyOptions) {
 	opts = &kettyOptions{}
 	iisUseKettyHttpExtend, err := proto.GetExtension(message.Options, kettyProto.E_UseKettyHttpExtend)
@@ -170,6 +176,13 @@ func getKettyOptions(message *pb.DescriptorProto) (opts *kettyOptions) {
         }
 	}
 
+	iHistogramBuckets, err := proto.GetExtension(message.Options, kettyProto.E_HistogramBuckets)
+	if err == nil {
+		if buckets, ok := iHistogramBuckets.([]float64); ok {
+			opts.histogramBuckets = buckets
+		}
+	}
+
 	return
 }
 
@@ -192,6 +205,13 @@ func (g *ketty) generateOptionMethods(message *pb.DescriptorProto, opts *kettyOp
 		g.P("}")
 		g.P()
     }
+
+	if len(opts.histogramBuckets) > 0 {
+		g.P("func (*", message.Name, ") KettyHistogramBuckets() []float64 {")
+		g.P("return []float64{", floatSliceLiteral(opts.histogramBuckets), "}")
+		g.P("}")
+		g.P()
+	}
 }
 
 // GenerateImports generates the import declaration for this file.
@@ -200,7 +220,12 @@ func (g *ketty) GenerateImports(file *generator.FileDescriptor) {
 		return
 	}
 	g.P("import (")
+	g.P(contextPkg, " ", strconv.Quote("context"))
 	g.P(kettyPkg, " ", strconv.Quote(path.Join(g.gen.ImportPrefix, kettyPkgPath)))
+	if g.metricsEnabled {
+		g.P(strconv.Quote("time"))
+		g.P("prometheus ", strconv.Quote("github.com/prometheus/client_golang/prometheus"))
+	}
 	g.P(")")
 	g.P()
 }
@@ -210,7 +235,10 @@ var reservedClientName = map[string]bool{
 // TODO: do we need any in gRPC?
 }
 
-func unexport(s string) string { return strings.ToLower(s[:1]) + s[1:] }
+// reservedServerName records whether a server name is reserved on the server side.
+var reservedServerName = map[string]bool{
+// TODO: do we need any in gRPC?
+}
 
 // generateService generates all the code for the named service.
 func (g *ketty) generateService(file *generator.FileDescriptor, service *pb.ServiceDescriptorProto, index int) {
@@ -226,7 +254,7 @@ func (g *ketty) generateService(file *generator.FileDescriptor, service *pb.Serv
 	// ketty handle
 	handleT := servName + "HandleT"
 	g.P(fmt.Sprintf("type %s struct {", handleT))
-	g.P("desc *grpc.ServiceDesc")
+	g.P("desc *", kettyPkg, ".ServiceDesc")
 	g.P("}")
 	g.P()
 
@@ -255,10 +283,15 @@ func (g *ketty) generateService(file *generator.FileDescriptor, service *pb.Serv
 	g.P("}")
 	g.P()
 
+	g.metricsVar = ""
+	if g.metricsEnabled {
+		g.generateMetricsCollector(file, servName, service)
+	}
+
 	// Methods
 	var methodIndex, streamIndex int
 	serviceDescVar := "_" + servName + "_serviceDesc"
-	
+
 	for _, method := range service.Method {
 		var descExpr string
 		if !method.GetServerStreaming() && !method.GetClientStreaming() {
@@ -272,6 +305,27 @@ func (g *ketty) generateService(file *generator.FileDescriptor, service *pb.Serv
 		}
 		g.generateClientMethod(servName, fullServName, serviceDescVar, method, descExpr)
 	}
+
+	// Server interface.
+	g.P("// Ketty", servName, "Server is the server API for ", servName, " service.")
+	g.P("type Ketty", servName, "Server interface {")
+	for _, method := range service.Method {
+		g.P(g.generateServerSignature(servName, method))
+	}
+	g.P("}")
+	g.P()
+
+	// Server registration.
+	g.P("func RegisterKetty", servName, "Server(s ", kettyPkg, ".Server, srv Ketty", servName, "Server) {")
+	g.P("s.RegisterService(&", serviceDescVar, ", srv)")
+	g.P("}")
+	g.P()
+
+	for _, method := range service.Method {
+		g.generateServerMethod(servName, fullServName, method)
+	}
+
+	g.generateServiceDescriptor(file, servName, fullServName, service)
 }
 
 // generateClientSignature returns the client-side signature for a method.
@@ -295,17 +349,289 @@ func (g *ketty) generateClientSignature(servName string, method *pb.MethodDescri
 func (g *ketty) generateClientMethod(servName, fullServName, serviceDescVar string, method *pb.MethodDescriptorProto, descExpr string) {
 	//sname := fmt.Sprintf("/%s/%s", fullServName, method.GetName())
 	methName := generator.CamelCase(method.GetName())
-	//inType := g.typeName(method.GetInputType())
+	inType := g.typeName(method.GetInputType())
 	outType := g.typeName(method.GetOutputType())
 
 	g.P("func (this *Ketty", servName, "Client) ", g.generateClientSignature(servName, method), "{")
-	g.P("out := new(", outType, ")")
-	g.P("err := ", fmt.Sprintf("this.client.Invoke(ctx, %sHandle, \"%s\", in, out)", servName, methName))
+	if !method.GetServerStreaming() && !method.GetClientStreaming() {
+		g.P("out := new(", outType, ")")
+		if g.metricsEnabled {
+			g.P("start := time.Now()")
+		}
+		g.P("err := ", fmt.Sprintf("this.client.Invoke(ctx, %sHandle, \"%s\", in, out, this.client.CallOptions()...)", servName, methName))
+		if g.metricsEnabled {
+			g.P(g.metricsVar, ".Counter.WithLabelValues(", strconv.Quote(methName), ", ", kettyPkg, ".StatusCode(err)).Inc()")
+			g.P(g.metricsVar, ".Histogram.WithLabelValues(", strconv.Quote(methName), ").Observe(time.Since(start).Seconds())")
+		}
+		g.P("if err != nil { return nil, err }")
+		g.P("return out, nil")
+		g.P("}")
+		g.P()
+		return
+	}
+
+	streamType := unexport(servName) + methName + "Client"
+	g.P("stream, err := this.client.NewStream(ctx, ", descExpr, ", ", fmt.Sprintf("%sHandle, %q", servName, methName), ")")
 	g.P("if err != nil { return nil, err }")
-	g.P("return out, nil")
+	g.P("x := &", streamType, "{stream}")
+	if !method.GetClientStreaming() {
+		g.P("if err := x.ClientStream.SendMsg(in); err != nil { return nil, err }")
+		g.P("if err := x.ClientStream.CloseSend(); err != nil { return nil, err }")
+	}
+	g.P("return x, nil")
 	g.P("}")
 	g.P()
-	return
+
+	genSend := method.GetClientStreaming()
+	genRecv := method.GetServerStreaming()
+	genCloseAndRecv := !method.GetServerStreaming()
+
+	g.P("type ", servName, "_", methName, "Client interface {")
+	if genSend {
+		g.P("Send(*", inType, ") error")
+	}
+	if genRecv {
+		g.P("Recv() (*", outType, ", error)")
+	}
+	if genCloseAndRecv {
+		g.P("CloseAndRecv() (*", outType, ", error)")
+	}
+	g.P(kettyPkg, ".ClientStream")
+	g.P("}")
+	g.P()
+
+	g.P("type ", streamType, " struct {")
+	g.P(kettyPkg, ".ClientStream")
+	g.P("}")
+	g.P()
+
+	if genSend {
+		g.P("func (x *", streamType, ") Send(m *", inType, ") error {")
+		g.P("return x.ClientStream.SendMsg(m)")
+		g.P("}")
+		g.P()
+	}
+	if genRecv {
+		g.P("func (x *", streamType, ") Recv() (*", outType, ", error) {")
+		g.P("m := new(", outType, ")")
+		g.P("if err := x.ClientStream.RecvMsg(m); err != nil { return nil, err }")
+		g.P("return m, nil")
+		g.P("}")
+		g.P()
+	}
+	if genCloseAndRecv {
+		g.P("func (x *", streamType, ") CloseAndRecv() (*", outType, ", error) {")
+		g.P("m := new(", outType, ")")
+		g.P("if err := x.ClientStream.RecvMsg(m); err != nil { return nil, err }")
+		g.P("return m, nil")
+		g.P("}")
+		g.P()
+	}
+}
+
+// generateServerSignature returns the server-side signature for a method.
+func (g *ketty) generateServerSignature(servName string, method *pb.MethodDescriptorProto) string {
+	origMethName := method.GetName()
+	methName := generator.CamelCase(origMethName)
+	if reservedServerName[methName] {
+		methName += "_"
+	}
+
+	var reqArgs []string
+	ret := "error"
+	if !method.GetClientStreaming() && !method.GetServerStreaming() {
+		reqArgs = append(reqArgs, contextPkg+".Context")
+	}
+	if !method.GetClientStreaming() {
+		reqArgs = append(reqArgs, "*"+g.typeName(method.GetInputType()))
+	}
+	if method.GetServerStreaming() || method.GetClientStreaming() {
+		reqArgs = append(reqArgs, servName+"_"+generator.CamelCase(origMethName)+"Server")
+	}
+	if !method.GetClientStreaming() && !method.GetServerStreaming() {
+		ret = "(*" + g.typeName(method.GetOutputType()) + ", error)"
+	}
+
+	return methName + "(" + strings.Join(reqArgs, ", ") + ") " + ret
+}
+
+// generateServerMethod generates the handler wired into the service descriptor, plus,
+// for streaming methods, the auxiliary server-stream wrapper type.
+func (g *ketty) generateServerMethod(servName, fullServName string, method *pb.MethodDescriptorProto) string {
+	methName := generator.CamelCase(method.GetName())
+	hname := fmt.Sprintf("_%s_%s_Handler", servName, methName)
+	inType := g.typeName(method.GetInputType())
+	outType := g.typeName(method.GetOutputType())
+
+	if !method.GetServerStreaming() && !method.GetClientStreaming() {
+		g.P("func ", hname, "(srv interface{}, ctx ", contextPkg, ".Context, dec func(interface{}) error) (interface{}, error) {")
+		g.P("in := new(", inType, ")")
+		g.P("if err := dec(in); err != nil { return nil, err }")
+		if !g.metricsEnabled {
+			g.P("return srv.(Ketty", servName, "Server).", methName, "(ctx, in)")
+			g.P("}")
+			g.P()
+			return hname
+		}
+		g.P("start := time.Now()")
+		g.P("out, err := srv.(Ketty", servName, "Server).", methName, "(ctx, in)")
+		g.P(g.metricsVar, ".Counter.WithLabelValues(", strconv.Quote(methName), ", ", kettyPkg, ".StatusCode(err)).Inc()")
+		g.P(g.metricsVar, ".Histogram.WithLabelValues(", strconv.Quote(methName), ").Observe(time.Since(start).Seconds())")
+		g.P("return out, err")
+		g.P("}")
+		g.P()
+		return hname
+	}
+
+	streamType := unexport(servName) + methName + "Server"
+	g.P("func ", hname, "(srv interface{}, stream ", kettyPkg, ".ServerStream) error {")
+	if !method.GetClientStreaming() {
+		g.P("m := new(", inType, ")")
+		g.P("if err := stream.RecvMsg(m); err != nil { return err }")
+		g.P("return srv.(Ketty", servName, "Server).", methName, "(m, &", streamType, "{stream})")
+	} else {
+		g.P("return srv.(Ketty", servName, "Server).", methName, "(&", streamType, "{stream})")
+	}
+	g.P("}")
+	g.P()
+
+	genSend := method.GetServerStreaming()
+	genSendAndClose := !method.GetServerStreaming()
+	genRecv := method.GetClientStreaming()
+
+	g.P("type ", servName, "_", methName, "Server interface {")
+	if genSend {
+		g.P("Send(*", outType, ") error")
+	}
+	if genSendAndClose {
+		g.P("SendAndClose(*", outType, ") error")
+	}
+	if genRecv {
+		g.P("Recv() (*", inType, ", error)")
+	}
+	g.P(kettyPkg, ".ServerStream")
+	g.P("}")
+	g.P()
+
+	g.P("type ", streamType, " struct {")
+	g.P(kettyPkg, ".ServerStream")
+	g.P("}")
+	g.P()
+
+	if genSend {
+		g.P("func (x *", streamType, ") Send(m *", outType, ") error {")
+		g.P("return x.ServerStream.SendMsg(m)")
+		g.P("}")
+		g.P()
+	}
+	if genSendAndClose {
+		g.P("func (x *", streamType, ") SendAndClose(m *", outType, ") error {")
+		g.P("return x.ServerStream.SendMsg(m)")
+		g.P("}")
+		g.P()
+	}
+	if genRecv {
+		g.P("func (x *", streamType, ") Recv() (*", inType, ", error) {")
+		g.P("m := new(", inType, ")")
+		g.P("if err := x.ServerStream.RecvMsg(m); err != nil { return nil, err }")
+		g.P("return m, nil")
+		g.P("}")
+		g.P()
+	}
+
+	return hname
+}
+
+// generateServiceDescriptor emits the _Xxx_serviceDesc that the ketty runtime
+// dispatches incoming calls through, mirroring grpc.ServiceDesc's Methods/Streams
+// split between unary and streaming RPCs.
+func (g *ketty) generateServiceDescriptor(file *generator.FileDescriptor, servName, fullServName string, service *pb.ServiceDescriptorProto) {
+	serviceDescVar := "_" + servName + "_serviceDesc"
+
+	g.P("var ", serviceDescVar, " = ", kettyPkg, ".ServiceDesc{")
+	g.P("ServiceName: ", strconv.Quote(fullServName), ",")
+	g.P("HandlerType: (*Ketty", servName, "Server)(nil),")
+
+	g.P("Methods: []", kettyPkg, ".MethodDesc{")
+	for _, method := range service.Method {
+		if method.GetServerStreaming() || method.GetClientStreaming() {
+			continue
+		}
+		methName := generator.CamelCase(method.GetName())
+		g.P("{")
+		g.P("MethodName: ", strconv.Quote(methName), ",")
+		g.P("Handler: _", servName, "_", methName, "_Handler,")
+		g.P("},")
+	}
+	g.P("},")
+
+	g.P("Streams: []", kettyPkg, ".StreamDesc{")
+	for _, method := range service.Method {
+		if !method.GetServerStreaming() && !method.GetClientStreaming() {
+			continue
+		}
+		methName := generator.CamelCase(method.GetName())
+		g.P("{")
+		g.P("StreamName: ", strconv.Quote(methName), ",")
+		g.P("Handler: _", servName, "_", methName, "_Handler,")
+		g.P("ServerStreams: ", strconv.FormatBool(method.GetServerStreaming()), ",")
+		g.P("ClientStreams: ", strconv.FormatBool(method.GetClientStreaming()), ",")
+		g.P("},")
+	}
+	g.P("},")
+
+	g.P("Metadata: ", strconv.Quote(file.GetName()), ",")
+	g.P("}")
+	g.P()
+}
+
+// generateMetricsCollector emits the per-service Prometheus collector that
+// the metrics-wrapped client and server methods below record against, plus a
+// RegisterKettyXxxMetrics helper so callers can attach it to their own
+// prometheus.Registerer. Only runs when the "metrics=prometheus" plugin
+// parameter is set; see Init.
+func (g *ketty) generateMetricsCollector(file *generator.FileDescriptor, servName string, service *pb.ServiceDescriptorProto) {
+	g.metricsVar = "_" + servName + "_metrics"
+
+	bucketsExpr := "nil"
+	if buckets := legacyServiceHistogramBuckets(file, service); len(buckets) > 0 {
+		bucketsExpr = "[]float64{" + floatSliceLiteral(buckets) + "}"
+	}
+
+	g.P("var ", g.metricsVar, " = ", kettyPkg, ".NewPrometheusCollector(", strconv.Quote(servName), ", []string{")
+	for _, method := range service.Method {
+		g.P(strconv.Quote(generator.CamelCase(method.GetName())), ",")
+	}
+	g.P("}, ", bucketsExpr, ")")
+	g.P()
+
+	g.P("// RegisterKetty", servName, "Metrics registers ", servName, "'s collector on reg.")
+	g.P("func RegisterKetty", servName, "Metrics(reg prometheus.Registerer) error {")
+	g.P("return reg.Register(", g.metricsVar, ")")
+	g.P("}")
+	g.P()
+}
+
+// legacyServiceHistogramBuckets returns the histogram buckets configured via the
+// "ketty_histogram_buckets" message option on the first of service's
+// request/response messages (in the same file) that sets one, or nil if none do.
+func legacyServiceHistogramBuckets(file *generator.FileDescriptor, service *pb.ServiceDescriptorProto) []float64 {
+	types := map[string]bool{}
+	for _, method := range service.Method {
+		types[method.GetInputType()] = true
+		types[method.GetOutputType()] = true
+	}
+
+	for _, message := range file.FileDescriptorProto.MessageType {
+		fullName := "." + file.GetPackage() + "." + message.GetName()
+		if !types[fullName] {
+			continue
+		}
+		if buckets := getKettyOptions(message).histogramBuckets; len(buckets) > 0 {
+			return buckets
+		}
+	}
+	return nil
 }
 
 
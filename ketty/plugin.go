@@ -0,0 +1,19 @@
+// +build legacy
+
+package ketty
+
+import (
+	"github.com/golang/protobuf/protoc-gen-go/generator"
+)
+
+// This file wires the ketty plugin into protoc-gen-go's own plugin registry,
+// so that `protoc --go_out=.` emits ketty bindings straight into the
+// generated *.pb.go alongside protoc-gen-go's own output.
+//
+// It is only compiled in with `go build -tags legacy`; ordinary builds (and
+// the standalone protoc-gen-ketty binary, see cmd/protoc-gen-ketty) do not
+// register this plugin, since they drive the equivalent genkService/
+// genkOptionMethods (genketty.go) via protogen instead.
+func init() {
+	generator.RegisterPlugin(new(ketty))
+}